@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/beacon/engine"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/rpc"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// EngineClient talks to the authenticated Engine API port of an execution
+// client, parallel to ExecutionClient which talks to the plain eth RPC port.
+// It is used to probe CL<->EL interactions across the merge boundary, such
+// as payload delivery and forkchoice updates.
+type EngineClient struct {
+	Type   ClientType
+	ID     int
+	RPCUrl string
+	RPC    *rpc.Client
+
+	// Lock
+	l sync.Mutex
+
+	// Context related
+	lastCtx    context.Context
+	lastCancel context.CancelFunc
+
+	// Latest observed metrics, read by ExecutionClient.GetDataPoint.
+	lastNewPayloadLatency time.Duration
+	lastForkchoiceLatency time.Duration
+	lastPayloadStatus     string
+	lastPayloadSize       uint64
+}
+
+// NewEngineClient dials the authenticated engine port at rpcUrl, signing
+// every request with a JWT derived from the HS256 secret stored at
+// jwtSecretPath (as written by an execution client's --jwt-secret flag).
+func NewEngineClient(clientType ClientType, id int, rpcUrl string, jwtSecretPath string) (*EngineClient, error) {
+	secret, err := loadJWTSecret(jwtSecretPath)
+	if err != nil {
+		return nil, err
+	}
+	rpcClient, err := rpc.DialOptions(context.Background(), rpcUrl, rpc.WithHTTPAuth(node.NewJWTAuth(secret)))
+	if err != nil {
+		return nil, err
+	}
+	ec := EngineClient{
+		Type:   clientType,
+		ID:     id,
+		RPCUrl: rpcUrl,
+		RPC:    rpcClient,
+	}
+	return &ec, nil
+}
+
+// loadJWTSecret reads the hex-encoded 32 byte shared secret written by an
+// execution client's --jwt-secret flag.
+func loadJWTSecret(path string) ([32]byte, error) {
+	var secret [32]byte
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return secret, fmt.Errorf("unable to read jwt secret: %v", err)
+	}
+	raw := strings.TrimPrefix(strings.TrimSpace(string(data)), "0x")
+	decoded, err := hex.DecodeString(raw)
+	if err != nil {
+		return secret, fmt.Errorf("invalid jwt secret: %v", err)
+	}
+	if len(decoded) != 32 {
+		return secret, fmt.Errorf("invalid jwt secret length: %d", len(decoded))
+	}
+	copy(secret[:], decoded)
+	return secret, nil
+}
+
+func (ec *EngineClient) Ctx() context.Context {
+	if ec.lastCtx != nil {
+		ec.lastCancel()
+	}
+	ec.lastCtx, ec.lastCancel = context.WithTimeout(context.Background(), 10*time.Second)
+	return ec.lastCtx
+}
+
+func (ec *EngineClient) String() string {
+	return ec.RPCUrl
+}
+
+func (ec *EngineClient) ClientType() ClientType {
+	return ec.Type
+}
+
+func (ec *EngineClient) ClientID() int {
+	return ec.ID
+}
+
+func (ec *EngineClient) Close() error {
+	ec.RPC.Close()
+	return nil
+}
+
+// LastNewPayloadLatency returns the latency of the most recently completed
+// engine_newPayload call, synchronized against concurrent engine calls.
+func (ec *EngineClient) LastNewPayloadLatency() time.Duration {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	return ec.lastNewPayloadLatency
+}
+
+// LastForkchoiceUpdatedLatency returns the latency of the most recently
+// completed engine_forkchoiceUpdated call, synchronized against concurrent
+// engine calls.
+func (ec *EngineClient) LastForkchoiceUpdatedLatency() time.Duration {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	return ec.lastForkchoiceLatency
+}
+
+// LastPayloadStatus returns the status string of the most recently
+// completed engine_newPayload/engine_forkchoiceUpdated call, synchronized
+// against concurrent engine calls.
+func (ec *EngineClient) LastPayloadStatus() string {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	return ec.lastPayloadStatus
+}
+
+// LastPayloadSize returns the size of the most recently submitted
+// engine_newPayload payload, synchronized against concurrent engine calls.
+func (ec *EngineClient) LastPayloadSize() uint64 {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	return ec.lastPayloadSize
+}
+
+// NewPayloadV1 calls engine_newPayloadV1 and records latency/size/status
+// metrics for later retrieval via GetDataPoint.
+func (ec *EngineClient) NewPayloadV1(payload *engine.ExecutableData) (engine.PayloadStatusV1, error) {
+	return ec.newPayload(payload, "engine_newPayloadV1")
+}
+
+// NewPayloadV2 calls engine_newPayloadV2 (post-Shanghai, carries withdrawals).
+func (ec *EngineClient) NewPayloadV2(payload *engine.ExecutableData) (engine.PayloadStatusV1, error) {
+	return ec.newPayload(payload, "engine_newPayloadV2")
+}
+
+// NewPayloadV3 calls engine_newPayloadV3 (post-Cancun, carries blob fields
+// and requires versioned hashes plus the parent beacon block root).
+func (ec *EngineClient) NewPayloadV3(payload *engine.ExecutableData, versionedHashes []common.Hash, parentBeaconBlockRoot common.Hash) (engine.PayloadStatusV1, error) {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	var status engine.PayloadStatusV1
+	start := time.Now()
+	err := ec.RPC.CallContext(ec.Ctx(), &status, "engine_newPayloadV3", payload, versionedHashes, parentBeaconBlockRoot)
+	ec.recordNewPayload(start, payload, status, err)
+	return status, err
+}
+
+func (ec *EngineClient) newPayload(payload *engine.ExecutableData, method string) (engine.PayloadStatusV1, error) {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	var status engine.PayloadStatusV1
+	start := time.Now()
+	err := ec.RPC.CallContext(ec.Ctx(), &status, method, payload)
+	ec.recordNewPayload(start, payload, status, err)
+	return status, err
+}
+
+func (ec *EngineClient) recordNewPayload(start time.Time, payload *engine.ExecutableData, status engine.PayloadStatusV1, err error) {
+	ec.lastNewPayloadLatency = time.Since(start)
+	size := uint64(len(payload.ExtraData))
+	for _, tx := range payload.Transactions {
+		size += uint64(len(tx))
+	}
+	ec.lastPayloadSize = size
+	if err != nil {
+		log15.Warn("engine_newPayload failed", "client", ec.ClientID(), "error", err)
+		return
+	}
+	ec.lastPayloadStatus = string(status.Status)
+}
+
+// ForkchoiceUpdatedV1 calls engine_forkchoiceUpdatedV1.
+func (ec *EngineClient) ForkchoiceUpdatedV1(state *engine.ForkchoiceStateV1, attributes *engine.PayloadAttributes) (engine.ForkChoiceResponse, error) {
+	return ec.forkchoiceUpdated(state, attributes, "engine_forkchoiceUpdatedV1")
+}
+
+// ForkchoiceUpdatedV2 calls engine_forkchoiceUpdatedV2.
+func (ec *EngineClient) ForkchoiceUpdatedV2(state *engine.ForkchoiceStateV1, attributes *engine.PayloadAttributes) (engine.ForkChoiceResponse, error) {
+	return ec.forkchoiceUpdated(state, attributes, "engine_forkchoiceUpdatedV2")
+}
+
+// ForkchoiceUpdatedV3 calls engine_forkchoiceUpdatedV3.
+func (ec *EngineClient) ForkchoiceUpdatedV3(state *engine.ForkchoiceStateV1, attributes *engine.PayloadAttributes) (engine.ForkChoiceResponse, error) {
+	return ec.forkchoiceUpdated(state, attributes, "engine_forkchoiceUpdatedV3")
+}
+
+func (ec *EngineClient) forkchoiceUpdated(state *engine.ForkchoiceStateV1, attributes *engine.PayloadAttributes, method string) (engine.ForkChoiceResponse, error) {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	var response engine.ForkChoiceResponse
+	start := time.Now()
+	err := ec.RPC.CallContext(ec.Ctx(), &response, method, state, attributes)
+	ec.lastForkchoiceLatency = time.Since(start)
+	if err != nil {
+		log15.Warn("engine_forkchoiceUpdated failed", "client", ec.ClientID(), "error", err)
+		return response, err
+	}
+	ec.lastPayloadStatus = string(response.PayloadStatus.Status)
+	return response, nil
+}
+
+// GetPayloadV1 calls engine_getPayloadV1.
+func (ec *EngineClient) GetPayloadV1(payloadID engine.PayloadID) (*engine.ExecutableData, error) {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	var payload engine.ExecutableData
+	if err := ec.RPC.CallContext(ec.Ctx(), &payload, "engine_getPayloadV1", payloadID); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// GetPayloadV2 calls engine_getPayloadV2.
+func (ec *EngineClient) GetPayloadV2(payloadID engine.PayloadID) (*engine.ExecutionPayloadEnvelope, error) {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	var envelope engine.ExecutionPayloadEnvelope
+	if err := ec.RPC.CallContext(ec.Ctx(), &envelope, "engine_getPayloadV2", payloadID); err != nil {
+		return nil, err
+	}
+	return &envelope, nil
+}
+
+// GetPayloadV3 calls engine_getPayloadV3.
+func (ec *EngineClient) GetPayloadV3(payloadID engine.PayloadID) (*engine.ExecutionPayloadEnvelope, error) {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	var envelope engine.ExecutionPayloadEnvelope
+	if err := ec.RPC.CallContext(ec.Ctx(), &envelope, "engine_getPayloadV3", payloadID); err != nil {
+		return nil, err
+	}
+	return &envelope, nil
+}
+
+// ExchangeCapabilities calls engine_exchangeCapabilities, advertising the
+// set of engine methods the prober itself understands and returning the
+// set the client supports.
+func (ec *EngineClient) ExchangeCapabilities(supported []string) ([]string, error) {
+	ec.l.Lock()
+	defer ec.l.Unlock()
+	var capabilities []string
+	if err := ec.RPC.CallContext(ec.Ctx(), &capabilities, "engine_exchangeCapabilities", supported); err != nil {
+		return nil, err
+	}
+	return capabilities, nil
+}
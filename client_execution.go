@@ -2,24 +2,29 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
 type ExecutionClient struct {
-	Type   ClientType
-	ID     int
-	RPCUrl string
-	Eth    *ethclient.Client
-	RPC    *rpc.Client
+	Type        ClientType
+	ID          int
+	RPCUrl      string
+	Eth         *ethclient.Client
+	RPC         *rpc.Client
+	IsWebsocket bool
 
 	// Merge related
 	TTD                TTD
@@ -27,14 +32,33 @@ type ExecutionClient struct {
 	TTDBlockTimestamp  uint64
 	UpdateTTDTimestamp func(uint64)
 
+	// Engine API client for the same node, used to collect the
+	// merge-boundary metrics in GetDataPoint. May be nil if the node was
+	// not configured with a --jwt-secret.
+	Engine *EngineClient
+
+	// Canonical-chain tracker, fed by recordHead as new heads arrive.
+	reorgMu            sync.Mutex
+	canonHashes        map[uint64]common.Hash
+	lastReorgDepth     uint64
+	lastReorgTimestamp uint64
+
 	// Lock
 	l sync.Mutex
 
-	// Context related
+	// Context related. Guarded by its own mutex rather than l, since Ctx()
+	// is called both from methods that hold l (GetDataPoint,
+	// UpdateGetTTDBlockSlot, ...) and from the background WatchTTDBlock/
+	// pollTTDBlock goroutine, which does not.
+	ctxMu      sync.Mutex
 	lastCtx    context.Context
 	lastCancel context.CancelFunc
 }
 
+// reorgWindowSize bounds both how many canonical hashes are remembered and
+// how far back a reorg is searched for before giving up.
+const reorgWindowSize = 256
+
 type TotalDifficulty struct {
 	TotalDifficulty *hexutil.Big `json:"totalDifficulty"`
 }
@@ -51,46 +75,132 @@ func (el *ExecutionClient) ClientVersion() (string, error) {
 	return *clientVersion, nil
 }
 
+// ttdProbe is the subset of an eth_getBlockByNumber response needed to
+// locate the TTD block, used instead of a full types.Block to keep the
+// binary search and its verification batch call cheap.
+type ttdProbe struct {
+	Number     hexutil.Uint64 `json:"number"`
+	Time       hexutil.Uint64 `json:"timestamp"`
+	Difficulty *hexutil.Big   `json:"difficulty"`
+}
+
+// ttdHeaderSource is the subset of *ethclient.Client used by the TTD binary
+// search, extracted as an interface so tests can stub it with a synthetic
+// chain instead of dialing a real node.
+type ttdHeaderSource interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// ttdCaller is the subset of *rpc.Client used to verify the TTD binary
+// search's candidate, extracted as an interface for the same reason.
+type ttdCaller interface {
+	BatchCallContext(ctx context.Context, batch []rpc.BatchElem) error
+}
+
 func (el *ExecutionClient) UpdateGetTTDBlockSlot() (*uint64, error) {
 	el.l.Lock()
 	defer el.l.Unlock()
 
-	if el.TTDBlockNumber == nil {
-		var td *TotalDifficulty
-		if err := el.RPC.CallContext(el.Ctx(), &td, "eth_getBlockByNumber", "latest", false); err != nil {
+	if el.TTDBlockNumber != nil {
+		return el.TTDBlockNumber, nil
+	}
+
+	var td *TotalDifficulty
+	if err := el.RPC.CallContext(el.Ctx(), &td, "eth_getBlockByNumber", "latest", false); err != nil {
+		return nil, err
+	}
+	if td.TotalDifficulty.ToInt().Cmp(el.TTD.Int) < 0 {
+		// TTD has not been reached yet.
+		return nil, nil
+	}
+
+	latestHeader, err := el.Eth.HeaderByNumber(el.Ctx(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ttdBlock, err := findTTDBlock(el.Ctx, el.Eth, el.RPC, latestHeader.Number.Uint64())
+	if err != nil {
+		return nil, err
+	}
+
+	bn := uint64(ttdBlock.Number)
+	el.TTDBlockNumber = &bn
+	el.TTDBlockTimestamp = uint64(ttdBlock.Time)
+	if el.UpdateTTDTimestamp != nil {
+		el.UpdateTTDTimestamp(el.TTDBlockTimestamp)
+	}
+	log15.Info("TTD Block Reached", "client", el.ClientID(), "block", bn)
+
+	return el.TTDBlockNumber, nil
+}
+
+// findTTDBlock binary searches [0, latest] for the pivot: the first block
+// with zero difficulty. Every block before the pivot has difficulty > 0,
+// every block from the pivot onwards has difficulty == 0 (post-merge blocks
+// add no work), so the search space is monotonic in that predicate. The TTD
+// block itself, matching EIP-3675's TERMINAL_BLOCK and the original
+// backward-scan's semantics, is the block immediately before the pivot: the
+// last block that still did PoW. ctxFn is called to obtain a fresh, bounded
+// context for each lookup, matching the el.Ctx() pattern used by the rest
+// of this file.
+func findTTDBlock(ctxFn func() context.Context, headers ttdHeaderSource, caller ttdCaller, latest uint64) (*ttdProbe, error) {
+	lo, hi := uint64(0), latest
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		header, err := headers.HeaderByNumber(ctxFn(), new(big.Int).SetUint64(mid))
+		if err != nil {
 			return nil, err
 		}
+		if header.Difficulty.Sign() == 0 {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	if lo == 0 {
+		return nil, fmt.Errorf("no terminal PoW block found below block %d", latest)
+	}
+	return verifyTTDBlock(ctxFn, caller, lo)
+}
 
-		if td.TotalDifficulty.ToInt().Cmp(el.TTD.Int) >= 0 {
-			// TTD has been reached, we need to go backwards from latest block to find the non-zero difficulty block
-			latestHeader, err := el.Eth.BlockByNumber(el.Ctx(), nil)
-			if err != nil {
-				return nil, err
-			}
-			for currentNumber := latestHeader.NumberU64(); currentNumber >= 0; currentNumber-- {
-				currentHeader, err := el.Eth.BlockByNumber(el.Ctx(), big.NewInt(int64(currentNumber)))
-				if err != nil {
-					return nil, err
-				}
-				if currentHeader.Difficulty().Cmp(big.NewInt(0)) > 0 {
-					// We got the first block from head with a non-zero difficulty, this is the TTD block
-					bn := currentHeader.Number().Uint64()
-					el.TTDBlockNumber = &bn
-					el.TTDBlockTimestamp = currentHeader.Time()
-					if el.UpdateTTDTimestamp != nil {
-						el.UpdateTTDTimestamp(el.TTDBlockTimestamp)
-					}
-					log15.Info("TTD Block Reached", "client", el.ClientID(), "block", bn)
-					break
-				}
-				if currentNumber == 0 {
-					return nil, fmt.Errorf("Unable to get TTD Block")
-				}
-			}
+// verifyTTDBlock fetches the binary search's pivot block (the first
+// zero-difficulty block) together with the block immediately before it in a
+// single batched RPC call, confirms the zero-difficulty transition happens
+// exactly at pivot, and returns the block before it: the terminal PoW
+// block.
+func verifyTTDBlock(ctxFn func() context.Context, caller ttdCaller, pivot uint64) (*ttdProbe, error) {
+	if pivot == 0 {
+		return nil, fmt.Errorf("no terminal PoW block precedes block 0")
+	}
+	numbers := []uint64{pivot - 1, pivot}
+
+	probes := make([]ttdProbe, len(numbers))
+	batch := make([]rpc.BatchElem, len(numbers))
+	for i, n := range numbers {
+		batch[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(n), false},
+			Result: &probes[i],
+		}
+	}
+	if err := caller.BatchCallContext(ctxFn(), batch); err != nil {
+		return nil, err
+	}
+	for i, elem := range batch {
+		if elem.Error != nil {
+			return nil, fmt.Errorf("unable to fetch block %d: %v", numbers[i], elem.Error)
 		}
 	}
 
-	return el.TTDBlockNumber, nil
+	terminalProbe, pivotProbe := &probes[0], &probes[1]
+	if pivotProbe.Difficulty.ToInt().Sign() != 0 {
+		return nil, fmt.Errorf("TTD pivot block %d has non-zero difficulty", pivot)
+	}
+	if terminalProbe.Difficulty.ToInt().Sign() == 0 {
+		return nil, fmt.Errorf("block preceding TTD pivot %d also has zero difficulty", pivot)
+	}
+	return terminalProbe, nil
 }
 
 func (el *ExecutionClient) GetLatestBlockSlotNumber() (uint64, error) {
@@ -147,12 +257,122 @@ func (el *ExecutionClient) GetDataPoint(dataName MetricName, blockNumber uint64)
 			return nil, err
 		}
 		return header.Nonce.Uint64(), nil
+	case PayloadNewLatency:
+		if el.Engine == nil {
+			return nil, fmt.Errorf("no engine client configured for %s", el)
+		}
+		return el.Engine.LastNewPayloadLatency(), nil
+	case ForkchoiceUpdatedLatency:
+		if el.Engine == nil {
+			return nil, fmt.Errorf("no engine client configured for %s", el)
+		}
+		return el.Engine.LastForkchoiceUpdatedLatency(), nil
+	case PayloadStatusCode:
+		if el.Engine == nil {
+			return nil, fmt.Errorf("no engine client configured for %s", el)
+		}
+		return el.Engine.LastPayloadStatus(), nil
+	case EnginePayloadSize:
+		if el.Engine == nil {
+			return nil, fmt.Errorf("no engine client configured for %s", el)
+		}
+		return el.Engine.LastPayloadSize(), nil
+	case BlockWithdrawalsRoot:
+		header, err := el.Eth.HeaderByNumber(el.Ctx(), big.NewInt(int64(blockNumber)))
+		if err != nil {
+			return nil, err
+		}
+		if header.WithdrawalsHash != nil {
+			return header.WithdrawalsHash.Big(), nil
+		}
+		var raw struct {
+			WithdrawalsRoot *common.Hash `json:"withdrawalsRoot"`
+		}
+		if err := el.rawBlockField(blockNumber, &raw); err != nil {
+			return nil, err
+		}
+		if raw.WithdrawalsRoot == nil {
+			return nil, fmt.Errorf("block %d has no withdrawals root", blockNumber)
+		}
+		return raw.WithdrawalsRoot.Big(), nil
+	case BlockWithdrawalsCount:
+		var raw struct {
+			Withdrawals []json.RawMessage `json:"withdrawals"`
+		}
+		if err := el.rawBlockField(blockNumber, &raw); err != nil {
+			return nil, err
+		}
+		return uint64(len(raw.Withdrawals)), nil
+	case BlockBlobGasUsed:
+		header, err := el.Eth.HeaderByNumber(el.Ctx(), big.NewInt(int64(blockNumber)))
+		if err != nil {
+			return nil, err
+		}
+		if header.BlobGasUsed != nil {
+			return *header.BlobGasUsed, nil
+		}
+		var raw struct {
+			BlobGasUsed *hexutil.Uint64 `json:"blobGasUsed"`
+		}
+		if err := el.rawBlockField(blockNumber, &raw); err != nil {
+			return nil, err
+		}
+		if raw.BlobGasUsed == nil {
+			return nil, fmt.Errorf("block %d has no blobGasUsed", blockNumber)
+		}
+		return uint64(*raw.BlobGasUsed), nil
+	case BlockExcessBlobGas:
+		header, err := el.Eth.HeaderByNumber(el.Ctx(), big.NewInt(int64(blockNumber)))
+		if err != nil {
+			return nil, err
+		}
+		if header.ExcessBlobGas != nil {
+			return *header.ExcessBlobGas, nil
+		}
+		var raw struct {
+			ExcessBlobGas *hexutil.Uint64 `json:"excessBlobGas"`
+		}
+		if err := el.rawBlockField(blockNumber, &raw); err != nil {
+			return nil, err
+		}
+		if raw.ExcessBlobGas == nil {
+			return nil, fmt.Errorf("block %d has no excessBlobGas", blockNumber)
+		}
+		return uint64(*raw.ExcessBlobGas), nil
+	case BlockParentBeaconBlockRoot:
+		header, err := el.Eth.HeaderByNumber(el.Ctx(), big.NewInt(int64(blockNumber)))
+		if err != nil {
+			return nil, err
+		}
+		if header.ParentBeaconRoot != nil {
+			return header.ParentBeaconRoot.Big(), nil
+		}
+		var raw struct {
+			ParentBeaconBlockRoot *common.Hash `json:"parentBeaconBlockRoot"`
+		}
+		if err := el.rawBlockField(blockNumber, &raw); err != nil {
+			return nil, err
+		}
+		if raw.ParentBeaconBlockRoot == nil {
+			return nil, fmt.Errorf("block %d has no parentBeaconBlockRoot", blockNumber)
+		}
+		return raw.ParentBeaconBlockRoot.Big(), nil
+	case ReorgDepth:
+		el.reorgMu.Lock()
+		defer el.reorgMu.Unlock()
+		return el.lastReorgDepth, nil
+	case ReorgTimestamp:
+		el.reorgMu.Lock()
+		defer el.reorgMu.Unlock()
+		return el.lastReorgTimestamp, nil
 	}
 
 	return nil, fmt.Errorf("Invalid data name: %s", dataName)
 }
 
 func (el *ExecutionClient) Ctx() context.Context {
+	el.ctxMu.Lock()
+	defer el.ctxMu.Unlock()
 	if el.lastCtx != nil {
 		el.lastCancel()
 	}
@@ -160,6 +380,85 @@ func (el *ExecutionClient) Ctx() context.Context {
 	return el.lastCtx
 }
 
+// recordHead feeds a newly observed head into the canonical-chain tracker.
+// A reorg is detected either as a same-height tip flip (a different hash
+// already recorded for this exact block number) or, more commonly, as the
+// chain advancing to a new height whose parent doesn't match the previously
+// recorded canonical hash one below it (an ancestor underneath the new tip
+// changed). Either case triggers reorgDepth to walk back through parent
+// hashes and measure how many blocks were replaced, recorded for
+// GetDataPoint.
+func (el *ExecutionClient) recordHead(header *types.Header) {
+	number := header.Number.Uint64()
+
+	el.reorgMu.Lock()
+	defer el.reorgMu.Unlock()
+
+	if el.canonHashes == nil {
+		el.canonHashes = make(map[uint64]common.Hash)
+	}
+
+	sameHeightFlip := false
+	if existing, ok := el.canonHashes[number]; ok && existing != header.Hash() {
+		sameHeightFlip = true
+	}
+	ancestorChanged := false
+	if number > 0 {
+		if parentCanon, ok := el.canonHashes[number-1]; ok && parentCanon != header.ParentHash {
+			ancestorChanged = true
+		}
+	}
+	if sameHeightFlip || ancestorChanged {
+		depth := el.reorgDepth(header)
+		if !sameHeightFlip && depth > 1 {
+			// The head sits at a height with no replaced entry, so it is not
+			// itself one of the replaced blocks.
+			depth--
+		}
+		el.lastReorgDepth = depth
+		el.lastReorgTimestamp = header.Time
+		log15.Warn("Reorg detected", "client", el.ClientID(), "block", number, "depth", depth)
+	}
+
+	el.canonHashes[number] = header.Hash()
+	for n := range el.canonHashes {
+		if n+reorgWindowSize <= number {
+			delete(el.canonHashes, n)
+		}
+	}
+}
+
+// reorgDepth walks back from head through parent hashes, using the bounded
+// el.Ctx() timeout for each lookup like every other RPC call in this file,
+// correcting stale canonical entries along the way, until it finds a parent
+// that matches the previously remembered canonical hash at that block
+// number (the common ancestor). It gives up after reorgWindowSize blocks,
+// since the canonical-hash ring buffer doesn't remember further back than
+// that anyway. Callers must hold el.reorgMu.
+func (el *ExecutionClient) reorgDepth(head *types.Header) uint64 {
+	current := head
+	for depth := uint64(1); depth <= reorgWindowSize; depth++ {
+		parentNumber := current.Number.Uint64() - 1
+		if canon, ok := el.canonHashes[parentNumber]; ok && canon == current.ParentHash {
+			return depth
+		}
+		parent, err := el.Eth.HeaderByHash(el.Ctx(), current.ParentHash)
+		if err != nil {
+			return depth
+		}
+		el.canonHashes[parentNumber] = parent.Hash()
+		current = parent
+	}
+	return reorgWindowSize
+}
+
+// rawBlockField issues an eth_getBlockByNumber call and decodes it into
+// dest, used as a fallback for header fields not exposed by types.Header on
+// older go-ethereum versions.
+func (el *ExecutionClient) rawBlockField(blockNumber uint64, dest interface{}) error {
+	return el.RPC.CallContext(el.Ctx(), dest, "eth_getBlockByNumber", hexutil.EncodeUint64(blockNumber), false)
+}
+
 func (el *ExecutionClient) String() string {
 	return el.RPCUrl
 }
@@ -178,19 +477,125 @@ func (el *ExecutionClient) Close() error {
 }
 
 func NewExecutionClient(clientType ClientType, id int, rpcUrl string) (*ExecutionClient, error) {
-	client := &http.Client{}
-	rpcClient, err := rpc.DialHTTPWithClient(rpcUrl, client)
+	parsed, err := url.Parse(rpcUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		rpcClient   *rpc.Client
+		isWebsocket bool
+	)
+	switch parsed.Scheme {
+	case "ws", "wss":
+		rpcClient, err = rpc.DialWebsocket(context.Background(), rpcUrl, "")
+		isWebsocket = true
+	default:
+		rpcClient, err = rpc.DialHTTPWithClient(rpcUrl, &http.Client{})
+	}
 	if err != nil {
 		return nil, err
 	}
 	eth := ethclient.NewClient(rpcClient)
 
 	el := ExecutionClient{
-		Type:   clientType,
-		ID:     id,
-		RPCUrl: rpcUrl,
-		Eth:    eth,
-		RPC:    rpcClient,
+		Type:        clientType,
+		ID:          id,
+		RPCUrl:      rpcUrl,
+		Eth:         eth,
+		RPC:         rpcClient,
+		IsWebsocket: isWebsocket,
 	}
 	return &el, nil
 }
+
+// SubscribeNewHeads opens an eth_subscribe("newHeads") subscription over the
+// client's connection and streams incoming headers into ch. It is only
+// useful when the client was dialed over a websocket endpoint; HTTP
+// transports do not support subscriptions and will return an error.
+func (el *ExecutionClient) SubscribeNewHeads(ch chan<- *types.Header) (*rpc.ClientSubscription, error) {
+	return el.RPC.EthSubscribe(context.Background(), ch, "newHeads")
+}
+
+// WatchTTDBlock tracks the TTD transition for as long as ctx is alive. When
+// the client was dialed over a websocket, it consumes a live newHeads
+// subscription so the transition (and any reorgs around it) are observed as
+// they happen; otherwise, and if the subscription cannot be established, it
+// falls back to polling UpdateGetTTDBlockSlot every pollInterval.
+func (el *ExecutionClient) WatchTTDBlock(ctx context.Context, pollInterval time.Duration) error {
+	if !el.IsWebsocket {
+		return el.pollTTDBlock(ctx, pollInterval)
+	}
+
+	headers := make(chan *types.Header)
+	sub, err := el.SubscribeNewHeads(headers)
+	if err != nil {
+		log15.Warn("Unable to subscribe to newHeads, falling back to polling", "client", el.ClientID(), "error", err)
+		return el.pollTTDBlock(ctx, pollInterval)
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-sub.Err():
+			log15.Warn("newHeads subscription dropped, falling back to polling", "client", el.ClientID(), "error", err)
+			return el.pollTTDBlock(ctx, pollInterval)
+		case header := <-headers:
+			el.recordHead(header)
+			if _, err := el.UpdateGetTTDBlockSlot(); err != nil {
+				log15.Warn("Unable to update TTD block slot", "client", el.ClientID(), "error", err)
+			}
+		}
+	}
+}
+
+// pollTTDBlock is the polling fallback used when no newHeads subscription is
+// available. Every pollInterval it fetches the latest head and feeds it to
+// the canonical-chain tracker the same way the websocket path does (so
+// ReorgDepth/ReorgTimestamp are tracked over HTTP too, not just websocket),
+// then calls UpdateGetTTDBlockSlot (a cheap no-op once the TTD block is
+// already known), until ctx is cancelled.
+func (el *ExecutionClient) pollTTDBlock(ctx context.Context, pollInterval time.Duration) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if header, err := el.Eth.HeaderByNumber(el.Ctx(), nil); err != nil {
+			log15.Warn("Unable to fetch latest header", "client", el.ClientID(), "error", err)
+		} else {
+			el.recordHead(header)
+		}
+		if _, err := el.UpdateGetTTDBlockSlot(); err != nil {
+			log15.Warn("Unable to update TTD block slot", "client", el.ClientID(), "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NewExecutionClientWithEngine behaves like NewExecutionClient but also
+// dials the node's authenticated engine port at engineUrl using the JWT
+// secret stored at jwtSecretPath, and attaches it as el.Engine so the
+// collector can pull the engine-related metrics through GetDataPoint.
+//
+// This file only provides the client; the collector loop that decides when
+// to call NewExecutionClientWithEngine instead of NewExecutionClient (i.e.
+// whenever --jwt-secret is configured for a node) and that actually drives
+// engine_newPayload/engine_forkchoiceUpdated/engine_getPayload during each
+// probe cycle lives outside this file and is not part of this change.
+func NewExecutionClientWithEngine(clientType ClientType, id int, rpcUrl string, engineUrl string, jwtSecretPath string) (*ExecutionClient, error) {
+	el, err := NewExecutionClient(clientType, id, rpcUrl)
+	if err != nil {
+		return nil, err
+	}
+	engine, err := NewEngineClient(clientType, id, engineUrl, jwtSecretPath)
+	if err != nil {
+		return nil, err
+	}
+	el.Engine = engine
+	return el, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// stubHeaderSource and stubCaller stand in for *ethclient.Client and
+// *rpc.Client, serving headers from an in-memory synthetic chain so
+// findTTDBlock/verifyTTDBlock can be exercised without dialing a real node.
+type stubHeaderSource struct {
+	headers map[uint64]*types.Header
+}
+
+func (s *stubHeaderSource) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	header, ok := s.headers[number.Uint64()]
+	if !ok {
+		return nil, fmt.Errorf("no header at block %d", number.Uint64())
+	}
+	return header, nil
+}
+
+type stubCaller struct {
+	headers map[uint64]*types.Header
+}
+
+func (s *stubCaller) BatchCallContext(ctx context.Context, batch []rpc.BatchElem) error {
+	for i := range batch {
+		if batch[i].Method != "eth_getBlockByNumber" {
+			return fmt.Errorf("unexpected method %q", batch[i].Method)
+		}
+		encoded, ok := batch[i].Args[0].(string)
+		if !ok {
+			return fmt.Errorf("unexpected argument type %T", batch[i].Args[0])
+		}
+		number, err := hexutil.DecodeUint64(encoded)
+		if err != nil {
+			return err
+		}
+		header, ok := s.headers[number]
+		if !ok {
+			batch[i].Error = fmt.Errorf("no header at block %d", number)
+			continue
+		}
+		probe := batch[i].Result.(*ttdProbe)
+		probe.Number = hexutil.Uint64(header.Number.Uint64())
+		probe.Time = hexutil.Uint64(header.Time)
+		probe.Difficulty = (*hexutil.Big)(header.Difficulty)
+	}
+	return nil
+}
+
+// syntheticChain builds a chain of `latest`+1 blocks where every block below
+// firstPoSBlock has non-zero difficulty and every block from firstPoSBlock
+// onwards has zero difficulty, mirroring the real invariant around the TTD
+// transition. The TTD block itself (EIP-3675's TERMINAL_BLOCK) is
+// firstPoSBlock-1, the last block that still did PoW, matching the original
+// backward-scan's definition.
+func syntheticChain(latest, firstPoSBlock uint64) map[uint64]*types.Header {
+	headers := make(map[uint64]*types.Header, latest+1)
+	for n := uint64(0); n <= latest; n++ {
+		difficulty := big.NewInt(1_000_000)
+		if n >= firstPoSBlock {
+			difficulty = big.NewInt(0)
+		}
+		headers[n] = &types.Header{
+			Number:     new(big.Int).SetUint64(n),
+			Time:       1_663_000_000 + n*12,
+			Difficulty: difficulty,
+		}
+	}
+	return headers
+}
+
+func backgroundCtx() context.Context { return context.Background() }
+
+func TestFindTTDBlock(t *testing.T) {
+	tests := []struct {
+		name          string
+		latest        uint64
+		firstPoSBlock uint64
+	}{
+		{"transition near genesis", 100, 1},
+		{"transition near head", 100, 99},
+		{"transition mid chain", 200, 123},
+		{"head is exactly the first PoS block", 50, 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := syntheticChain(tt.latest, tt.firstPoSBlock)
+			src := &stubHeaderSource{headers: headers}
+			caller := &stubCaller{headers: headers}
+
+			got, err := findTTDBlock(backgroundCtx, src, caller, tt.latest)
+			if err != nil {
+				t.Fatalf("findTTDBlock() error = %v", err)
+			}
+			// The TTD block is the terminal PoW block: the one immediately
+			// before the first PoS block, matching the original
+			// backward-scan semantics (not the first PoS block itself).
+			wantTerminal := tt.firstPoSBlock - 1
+			if uint64(got.Number) != wantTerminal {
+				t.Fatalf("findTTDBlock() = block %d, want terminal PoW block %d", got.Number, wantTerminal)
+			}
+		})
+	}
+}
+
+func TestFindTTDBlockGenesisAlreadyPoS(t *testing.T) {
+	// Every block, including genesis, already has zero difficulty: there is
+	// no terminal PoW block to report.
+	headers := syntheticChain(10, 0)
+	src := &stubHeaderSource{headers: headers}
+	caller := &stubCaller{headers: headers}
+
+	if _, err := findTTDBlock(backgroundCtx, src, caller, 10); err == nil {
+		t.Fatal("findTTDBlock() error = nil, want error when no terminal PoW block exists")
+	}
+}
+
+func TestVerifyTTDBlockAmbiguousTransition(t *testing.T) {
+	// The block preceding the pivot also has zero difficulty, so there is no
+	// unambiguous terminal PoW block: verifyTTDBlock must reject it rather
+	// than silently picking one of two zero-difficulty blocks.
+	headers := map[uint64]*types.Header{
+		9:  {Number: big.NewInt(9), Time: 1000, Difficulty: big.NewInt(0)},
+		10: {Number: big.NewInt(10), Time: 1012, Difficulty: big.NewInt(0)},
+	}
+	caller := &stubCaller{headers: headers}
+
+	if _, err := verifyTTDBlock(backgroundCtx, caller, 10); err == nil {
+		t.Fatal("verifyTTDBlock() error = nil, want error for ambiguous TTD transition")
+	}
+}
+
+func TestVerifyTTDBlockPivotHasNonZeroDifficulty(t *testing.T) {
+	headers := map[uint64]*types.Header{
+		9:  {Number: big.NewInt(9), Time: 1000, Difficulty: big.NewInt(1_000_000)},
+		10: {Number: big.NewInt(10), Time: 1012, Difficulty: big.NewInt(1_000_000)},
+	}
+	caller := &stubCaller{headers: headers}
+
+	if _, err := verifyTTDBlock(backgroundCtx, caller, 10); err == nil {
+		t.Fatal("verifyTTDBlock() error = nil, want error for non-zero difficulty pivot")
+	}
+}
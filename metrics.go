@@ -0,0 +1,34 @@
+package main
+
+// MetricName identifies a single data point the prober knows how to collect
+// from a client, via ExecutionClient.GetDataPoint.
+type MetricName string
+
+const (
+	BlockCount      MetricName = "BlockCount"
+	BlockBaseFee    MetricName = "BlockBaseFee"
+	BlockGasUsed    MetricName = "BlockGasUsed"
+	BlockDifficulty MetricName = "BlockDifficulty"
+	BlockMixHash    MetricName = "BlockMixHash"
+	BlockUnclesHash MetricName = "BlockUnclesHash"
+	BlockNonce      MetricName = "BlockNonce"
+
+	// Engine API / merge-boundary metrics, sourced from the EngineClient
+	// paired with an ExecutionClient rather than from the plain eth RPC.
+	PayloadNewLatency        MetricName = "PayloadNewLatency"
+	ForkchoiceUpdatedLatency MetricName = "ForkchoiceUpdatedLatency"
+	PayloadStatusCode        MetricName = "PayloadStatusCode"
+	EnginePayloadSize        MetricName = "EnginePayloadSize"
+
+	// Post-Shanghai/Cancun header fields, so the same prober can cover
+	// forks beyond the merge without a fork per hard fork.
+	BlockWithdrawalsRoot       MetricName = "BlockWithdrawalsRoot"
+	BlockWithdrawalsCount      MetricName = "BlockWithdrawalsCount"
+	BlockBlobGasUsed           MetricName = "BlockBlobGasUsed"
+	BlockExcessBlobGas         MetricName = "BlockExcessBlobGas"
+	BlockParentBeaconBlockRoot MetricName = "BlockParentBeaconBlockRoot"
+
+	// Chain-quality metrics from the canonical-chain tracker.
+	ReorgDepth     MetricName = "ReorgDepth"
+	ReorgTimestamp MetricName = "ReorgTimestamp"
+)
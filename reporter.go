@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// EthstatsReporter pushes the metrics collected via
+// ExecutionClient.GetDataPoint to an ethstats-compatible dashboard, so a
+// merge-boundary probe run can be watched live rather than only consumed
+// from the tool's file output afterwards. Each client is reported under
+// its own node id, so multiple probed clients show up as distinct nodes.
+type EthstatsReporter struct {
+	URL     string
+	Secret  string
+	Clients []*ExecutionClient
+
+	conn *websocket.Conn
+}
+
+// NewEthstatsReporter builds a reporter that will push stats for clients to
+// the ethstats server at url, authenticating with secret.
+func NewEthstatsReporter(url string, secret string, clients []*ExecutionClient) *EthstatsReporter {
+	return &EthstatsReporter{
+		URL:     url,
+		Secret:  secret,
+		Clients: clients,
+	}
+}
+
+// Run dials the stats server, announces every client with a "hello" frame,
+// then pushes "block"/"latency"/"stats"/"pending" frames for each client
+// every interval until ctx is cancelled.
+func (r *EthstatsReporter) Run(ctx context.Context, interval time.Duration) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, r.URL, nil)
+	if err != nil {
+		return fmt.Errorf("unable to dial ethstats server: %v", err)
+	}
+	r.conn = conn
+	defer r.conn.Close()
+
+	for _, client := range r.Clients {
+		if err := r.sendHello(client); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			for _, client := range r.Clients {
+				if err := r.report(client); err != nil {
+					log15.Warn("Unable to report stats", "client", client.ClientID(), "error", err)
+				}
+			}
+		}
+	}
+}
+
+// emit writes a single ethstats frame, {"emit": [event, data]}.
+func (r *EthstatsReporter) emit(event string, data interface{}) error {
+	return r.conn.WriteJSON(map[string]interface{}{
+		"emit": []interface{}{event, data},
+	})
+}
+
+func (r *EthstatsReporter) sendHello(client *ExecutionClient) error {
+	return r.emit("hello", map[string]interface{}{
+		"id":     ethstatsNodeID(client),
+		"secret": r.Secret,
+		"info": map[string]interface{}{
+			"name":             ethstatsNodeID(client),
+			"node":             client.ClientType(),
+			"contact":          "",
+			"canUpdateHistory": false,
+		},
+	})
+}
+
+// report pulls the current data points for client through GetDataPoint and
+// pushes them as a round of block/latency/stats/pending frames, rather than
+// re-implementing header fetches.
+func (r *EthstatsReporter) report(client *ExecutionClient) error {
+	id := ethstatsNodeID(client)
+
+	number, err := client.GetLatestBlockSlotNumber()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if _, err := client.GetDataPoint(BlockCount, number); err != nil {
+		return err
+	}
+	latency := time.Since(start)
+
+	baseFee, err := client.GetDataPoint(BlockBaseFee, number)
+	if err != nil {
+		return err
+	}
+	gasUsed, err := client.GetDataPoint(BlockGasUsed, number)
+	if err != nil {
+		return err
+	}
+
+	if err := r.emit("block", map[string]interface{}{
+		"id": id,
+		"block": map[string]interface{}{
+			"number":  number,
+			"baseFee": baseFee,
+			"gasUsed": gasUsed,
+		},
+	}); err != nil {
+		return err
+	}
+
+	if err := r.emit("latency", map[string]interface{}{
+		"id":      id,
+		"latency": latency.Milliseconds(),
+	}); err != nil {
+		return err
+	}
+
+	if err := r.emit("stats", map[string]interface{}{
+		"id": id,
+		"stats": map[string]interface{}{
+			"active":  true,
+			"syncing": false,
+		},
+	}); err != nil {
+		return err
+	}
+
+	return r.emit("pending", map[string]interface{}{
+		"id":      id,
+		"pending": 0,
+	})
+}
+
+func ethstatsNodeID(client *ExecutionClient) string {
+	return fmt.Sprintf("%s-%d", client.ClientType(), client.ClientID())
+}